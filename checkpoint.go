@@ -0,0 +1,87 @@
+package koonkie
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// FileCheckpointStore persists a follower position as plain text in a file
+// on disk.
+type FileCheckpointStore struct {
+	path string
+}
+
+// NewFileCheckpointStore creates a CheckpointStore that persists the
+// position to the file at path.
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{
+		path: path,
+	}
+}
+
+var _ CheckpointStore = &FileCheckpointStore{}
+
+// Load implements CheckpointStore.
+func (s *FileCheckpointStore) Load(_ context.Context) (int64, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("read checkpoint file: %w", err)
+	}
+
+	position, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse checkpoint position: %w", err)
+	}
+
+	return position, nil
+}
+
+// Save implements CheckpointStore.
+func (s *FileCheckpointStore) Save(_ context.Context, position int64) error {
+	err := os.WriteFile(s.path, []byte(strconv.FormatInt(position, 10)), 0o600)
+	if err != nil {
+		return fmt.Errorf("write checkpoint file: %w", err)
+	}
+
+	return nil
+}
+
+// MemoryCheckpointStore is an in-memory CheckpointStore, primarily useful in
+// tests.
+type MemoryCheckpointStore struct {
+	mu       sync.Mutex
+	position int64
+}
+
+// NewMemoryCheckpointStore creates a CheckpointStore that keeps the position
+// in memory, optionally starting from an initial position.
+func NewMemoryCheckpointStore(initial int64) *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{
+		position: initial,
+	}
+}
+
+var _ CheckpointStore = &MemoryCheckpointStore{}
+
+// Load implements CheckpointStore.
+func (s *MemoryCheckpointStore) Load(_ context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.position, nil
+}
+
+// Save implements CheckpointStore.
+func (s *MemoryCheckpointStore) Save(_ context.Context, position int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.position = position
+
+	return nil
+}
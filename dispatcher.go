@@ -0,0 +1,436 @@
+package koonkie
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ttab/elephant-api/repository"
+)
+
+// DispatcherOptions configures a Dispatcher.
+type DispatcherOptions struct {
+	// Workers is the number of worker goroutines events are sharded
+	// across. Defaults to 1.
+	Workers int
+	// QueueDepth is the number of events buffered per worker before
+	// dispatching blocks. Defaults to 1.
+	QueueDepth int
+	// KeyFunc returns the ordering key for an event, typically the
+	// document UUID. Events with the same key are always processed in
+	// order by the same worker. Defaults to the event's UUID.
+	KeyFunc func(item *repository.EventlogItem) string
+	// Checkpoint, if set, is loaded once at start and saved every time
+	// the persisted position can safely advance, i.e. once every event
+	// in a polled batch and in every batch polled before it has been
+	// acknowledged by its worker. The saved
+	// value is the follower's scan position for that batch rather than
+	// an event ID, so the checkpoint keeps advancing even when a filter
+	// drops most or all events from a batch.
+	Checkpoint CheckpointStore
+	// Metrics reports worker count, queue depth and per-worker lag.
+	// Defaults to a no-op implementation.
+	Metrics DispatcherMetrics
+}
+
+// NewDispatcher creates a Dispatcher that fans events from lf out across N
+// worker goroutines, sharded by KeyFunc so that events for the same key are
+// always handled in order.
+func NewDispatcher(lf *LogFollower, opts DispatcherOptions) *Dispatcher {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	queueDepth := opts.QueueDepth
+	if queueDepth < 1 {
+		queueDepth = 1
+	}
+
+	metrics := opts.Metrics
+	if metrics == nil {
+		metrics = noopDispatcherMetrics{}
+	}
+
+	return &Dispatcher{
+		lf:         lf,
+		workers:    workers,
+		queueDepth: queueDepth,
+		keyFunc:    opts.KeyFunc,
+		checkpoint: opts.Checkpoint,
+		metrics:    metrics,
+	}
+}
+
+// Dispatcher runs a LogFollower and fans the events it produces out across a
+// pool of worker goroutines, while preserving per-key ordering and only
+// advancing the checkpoint past the follower's scan position once every
+// event up to it has already been acknowledged.
+type Dispatcher struct {
+	lf         *LogFollower
+	workers    int
+	queueDepth int
+	keyFunc    func(item *repository.EventlogItem) string
+	checkpoint CheckpointStore
+	metrics    DispatcherMetrics
+}
+
+// Run drives the underlying LogFollower and dispatches every event it
+// produces to handle, sharded by the dispatcher's KeyFunc. It blocks until
+// ctx is cancelled, at which point it stops accepting new events, waits for
+// in-flight events to finish, persists a final checkpoint and returns
+// ctx.Err(). If handle returns an error, Run stops dispatching new events,
+// drains in-flight work and returns that error.
+func (d *Dispatcher) Run(
+	ctx context.Context,
+	handle func(ctx context.Context, item *repository.EventlogItem) error,
+) error {
+	if d.checkpoint != nil {
+		position, err := d.checkpoint.Load(ctx)
+		if err != nil {
+			return fmt.Errorf("load checkpoint: %w", err)
+		}
+
+		d.lf.position = position
+	}
+
+	runCtx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+
+	shards := make([]chan dispatcherJob, d.workers)
+	for i := range shards {
+		shards[i] = make(chan dispatcherJob, d.queueDepth)
+	}
+
+	acked := make(chan *dispatchBatch, d.workers*d.queueDepth)
+
+	var wg sync.WaitGroup
+
+	d.metrics.SetWorkerCount(d.workers)
+
+	for i, shard := range shards {
+		wg.Add(1)
+
+		go func(worker int, jobs chan dispatcherJob) {
+			defer wg.Done()
+
+			d.runWorker(runCtx, worker, jobs, handle, acked, abort)
+		}(i, shard)
+	}
+
+	go func() {
+		wg.Wait()
+		close(acked)
+	}()
+
+	batches := make(chan *dispatchBatch)
+
+	go d.poll(runCtx, batches)
+
+	pending := newPendingWindow()
+
+	batchesCh := batches
+	ackedCh := acked
+
+	var firstCheckpointErr error
+
+	saveCheckpoint := func(position int64) {
+		if d.checkpoint == nil || firstCheckpointErr != nil {
+			return
+		}
+
+		if err := d.checkpoint.Save(ctx, position); err != nil {
+			firstCheckpointErr = fmt.Errorf("save checkpoint: %w", err)
+		}
+	}
+
+	for batchesCh != nil || ackedCh != nil {
+		select {
+		case batch, ok := <-batchesCh:
+			if !ok {
+				batchesCh = nil
+
+				for _, shard := range shards {
+					close(shard)
+				}
+
+				continue
+			}
+
+			if safe, advanced := pending.addBatch(batch); advanced {
+				saveCheckpoint(safe)
+			}
+
+			for _, item := range batch.items {
+				worker := d.workerFor(item)
+				job := dispatcherJob{item: item, batch: batch}
+
+				select {
+				case shards[worker] <- job:
+					d.metrics.SetQueueDepth(worker, len(shards[worker]))
+				case <-runCtx.Done():
+				}
+			}
+
+		case batch, ok := <-ackedCh:
+			if !ok {
+				ackedCh = nil
+
+				continue
+			}
+
+			if safe, advanced := pending.ack(batch); advanced {
+				saveCheckpoint(safe)
+			}
+		}
+	}
+
+	if firstCheckpointErr != nil {
+		return firstCheckpointErr
+	}
+
+	if cause := context.Cause(runCtx); cause != nil && !errors.Is(cause, context.Canceled) {
+		return cause
+	}
+
+	return ctx.Err()
+}
+
+// dispatchBatch is the set of items a single GetNext call produced, together
+// with the follower's scan position once that call returned. The position is
+// what actually becomes the checkpoint, since a heavily filtered follower can
+// scan far ahead of the last event it delivered.
+type dispatchBatch struct {
+	items     []*repository.EventlogItem
+	position  int64
+	remaining int
+}
+
+type dispatcherJob struct {
+	item  *repository.EventlogItem
+	batch *dispatchBatch
+}
+
+func (d *Dispatcher) poll(
+	ctx context.Context,
+	out chan<- *dispatchBatch,
+) {
+	defer close(out)
+
+	var attempt int
+
+	for ctx.Err() == nil {
+		items, err := d.lf.GetNext(ctx)
+		if err != nil {
+			if waitErr := d.lf.backoff(ctx, attempt); waitErr != nil {
+				return
+			}
+
+			attempt++
+
+			continue
+		}
+
+		position, _ := d.lf.GetState()
+
+		if len(items) == 0 {
+			// The scan may still have advanced (e.g. a filtered
+			// compacted window), so report it even though there's
+			// nothing to dispatch.
+			select {
+			case out <- &dispatchBatch{position: position}:
+			case <-ctx.Done():
+				return
+			}
+
+			if waitErr := sleepContext(ctx, d.lf.waitDuration); waitErr != nil {
+				return
+			}
+
+			continue
+		}
+
+		attempt = 0
+
+		select {
+		case out <- &dispatchBatch{items: items, position: position, remaining: len(items)}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) runWorker(
+	ctx context.Context,
+	worker int,
+	jobs <-chan dispatcherJob,
+	handle func(ctx context.Context, item *repository.EventlogItem) error,
+	acked chan<- *dispatchBatch,
+	abort context.CancelCauseFunc,
+) {
+	for job := range jobs {
+		err := handle(ctx, job.item)
+		if err != nil {
+			abort(fmt.Errorf("worker %d: handle event %d: %w", worker, job.item.Id, err))
+
+			return
+		}
+
+		acked <- job.batch
+
+		d.metrics.SetWorkerLag(worker, int64(len(jobs)))
+	}
+}
+
+func (d *Dispatcher) workerFor(item *repository.EventlogItem) int {
+	if d.workers == 1 {
+		return 0
+	}
+
+	key := item.Uuid
+	if d.keyFunc != nil {
+		key = d.keyFunc(item)
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	return int(h.Sum32() % uint32(d.workers)) //nolint:gosec
+}
+
+// pendingWindow tracks in-flight batches in poll order and reports the
+// highest follower scan position that has become safe to checkpoint, i.e.
+// the position of the latest batch such that every earlier batch's items
+// have all been acknowledged. A batch with no items (everything filtered
+// out by the follower) is safe as soon as it's added, so a heavy filter no
+// longer stalls the checkpoint the way it would if safety were tracked per
+// acked item instead.
+type pendingWindow struct {
+	order []*dispatchBatch
+}
+
+func newPendingWindow() *pendingWindow {
+	return &pendingWindow{}
+}
+
+func (w *pendingWindow) addBatch(b *dispatchBatch) (int64, bool) {
+	w.order = append(w.order, b)
+
+	return w.advance()
+}
+
+func (w *pendingWindow) ack(b *dispatchBatch) (int64, bool) {
+	b.remaining--
+
+	return w.advance()
+}
+
+func (w *pendingWindow) advance() (int64, bool) {
+	var safe int64
+
+	var advanced bool
+
+	for len(w.order) > 0 && w.order[0].remaining <= 0 {
+		safe = w.order[0].position
+		advanced = true
+
+		w.order = w.order[1:]
+	}
+
+	return safe, advanced
+}
+
+// DispatcherMetrics is the instrumentation hook for Dispatcher.
+type DispatcherMetrics interface {
+	// SetWorkerCount reports the configured number of workers.
+	SetWorkerCount(n int)
+	// SetQueueDepth reports how many events are currently buffered for
+	// the given worker.
+	SetQueueDepth(worker int, depth int)
+	// SetWorkerLag reports how many events are buffered for the given
+	// worker, sampled right after that worker finishes handling an
+	// event.
+	SetWorkerLag(worker int, lag int64)
+}
+
+var _ DispatcherMetrics = noopDispatcherMetrics{}
+
+type noopDispatcherMetrics struct{}
+
+func (noopDispatcherMetrics) SetWorkerCount(int)      {}
+func (noopDispatcherMetrics) SetQueueDepth(int, int)  {}
+func (noopDispatcherMetrics) SetWorkerLag(int, int64) {}
+
+// NewPrometheusDispatcherMetrics creates a DispatcherMetrics that publishes
+// worker count, queue depth and per-worker lag to reg.
+func NewPrometheusDispatcherMetrics(
+	reg prometheus.Registerer, dispatcherName string,
+) (*PrometheusDispatcherMetrics, error) {
+	workerCount := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "eventlog_dispatcher_workers",
+			Help: "Configured number of dispatcher workers",
+		},
+		[]string{"dispatcher"},
+	)
+	if err := reg.Register(workerCount); err != nil {
+		return nil, fmt.Errorf("failed to register worker count metric: %w", err)
+	}
+
+	queueDepth := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "eventlog_dispatcher_queue_depth",
+			Help: "Number of events currently buffered for a dispatcher worker",
+		},
+		[]string{"dispatcher", "worker"},
+	)
+	if err := reg.Register(queueDepth); err != nil {
+		return nil, fmt.Errorf("failed to register queue depth metric: %w", err)
+	}
+
+	workerLag := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "eventlog_dispatcher_worker_lag",
+			Help: "Number of events buffered for a dispatcher worker after its last completed event",
+		},
+		[]string{"dispatcher", "worker"},
+	)
+	if err := reg.Register(workerLag); err != nil {
+		return nil, fmt.Errorf("failed to register worker lag metric: %w", err)
+	}
+
+	return &PrometheusDispatcherMetrics{
+		name:        dispatcherName,
+		workerCount: workerCount,
+		queueDepth:  queueDepth,
+		workerLag:   workerLag,
+	}, nil
+}
+
+var _ DispatcherMetrics = &PrometheusDispatcherMetrics{}
+
+type PrometheusDispatcherMetrics struct {
+	name        string
+	workerCount *prometheus.GaugeVec
+	queueDepth  *prometheus.GaugeVec
+	workerLag   *prometheus.GaugeVec
+}
+
+// SetWorkerCount implements DispatcherMetrics.
+func (p *PrometheusDispatcherMetrics) SetWorkerCount(n int) {
+	p.workerCount.WithLabelValues(p.name).Set(float64(n))
+}
+
+// SetQueueDepth implements DispatcherMetrics.
+func (p *PrometheusDispatcherMetrics) SetQueueDepth(worker int, depth int) {
+	p.queueDepth.WithLabelValues(p.name, strconv.Itoa(worker)).Set(float64(depth))
+}
+
+// SetWorkerLag implements DispatcherMetrics.
+func (p *PrometheusDispatcherMetrics) SetWorkerLag(worker int, lag int64) {
+	p.workerLag.WithLabelValues(p.name, strconv.Itoa(worker)).Set(float64(lag))
+}
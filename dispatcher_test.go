@@ -0,0 +1,123 @@
+package koonkie
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/ttab/elephant-api/repository"
+)
+
+func TestPendingWindowAdvancesInOrder(t *testing.T) {
+	w := newPendingWindow()
+
+	a := &dispatchBatch{position: 10, remaining: 2}
+	b := &dispatchBatch{position: 20, remaining: 1}
+
+	if _, advanced := w.addBatch(a); advanced {
+		t.Fatal("addBatch(a) advanced before any acks")
+	}
+
+	if _, advanced := w.addBatch(b); advanced {
+		t.Fatal("addBatch(b) advanced before any acks")
+	}
+
+	// Acking b's only item first must not advance the checkpoint past a,
+	// since a is still pending and ordered first.
+	if _, advanced := w.ack(b); advanced {
+		t.Fatal("ack(b) advanced out of order while a was still pending")
+	}
+
+	// First ack for a isn't enough, it needs both of its items.
+	if _, advanced := w.ack(a); advanced {
+		t.Fatal("ack(a) advanced after only one of its two items was acked")
+	}
+
+	// The second ack for a completes it, and since b was already fully
+	// acked it should advance straight through to b's position.
+	safe, advanced := w.ack(a)
+	if !advanced {
+		t.Fatal("final ack(a) did not advance the checkpoint")
+	}
+
+	if safe != 20 {
+		t.Fatalf("safe position = %d, want 20", safe)
+	}
+}
+
+func TestPendingWindowZeroItemBatchAdvancesImmediately(t *testing.T) {
+	w := newPendingWindow()
+
+	safe, advanced := w.addBatch(&dispatchBatch{position: 5})
+	if !advanced {
+		t.Fatal("addBatch of an empty batch did not advance immediately")
+	}
+
+	if safe != 5 {
+		t.Fatalf("safe position = %d, want 5", safe)
+	}
+}
+
+func TestDispatcherRunAdvancesCheckpointPastFilteredBatches(t *testing.T) {
+	docs := &fakeDocuments{
+		eventlog: [][]*repository.EventlogItem{
+			// Every item here is filtered out client-side, but the
+			// follower's scan position still advances to 2.
+			{{Id: 1, Uuid: "doc-a", Event: "status"}, {Id: 2, Uuid: "doc-b", Event: "status"}},
+			// Only 3 is delivered, but the scan position advances
+			// to 4.
+			{{Id: 3, Uuid: "doc-c", Event: "document"}, {Id: 4, Uuid: "doc-d", Event: "status"}},
+		},
+	}
+
+	checkpoint := NewMemoryCheckpointStore(0)
+
+	lf := NewLogFollower(docs, FollowerOptions{
+		CaughtUp:   true,
+		EventTypes: []string{"document"},
+	})
+
+	d := NewDispatcher(lf, DispatcherOptions{
+		Workers:    2,
+		Checkpoint: checkpoint,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var (
+		mu      sync.Mutex
+		handled []int64
+	)
+
+	err := d.Run(ctx, func(_ context.Context, item *repository.EventlogItem) error {
+		mu.Lock()
+		handled = append(handled, item.Id)
+		mu.Unlock()
+
+		cancel()
+
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run returned %v, want context.Canceled", err)
+	}
+
+	sort.Slice(handled, func(i, j int) bool { return handled[i] < handled[j] })
+
+	if len(handled) != 1 || handled[0] != 3 {
+		t.Fatalf("got handled items %v, want [3]", handled)
+	}
+
+	position, err := checkpoint.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	// The checkpoint must reach the follower's scan position (4), not
+	// just the ID of the one event that was actually delivered (3).
+	if position != 4 {
+		t.Fatalf("checkpoint position = %d, want 4", position)
+	}
+}
@@ -0,0 +1,46 @@
+package koonkie
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ttab/elephant-api/repository"
+)
+
+// fakeDocuments is a minimal repository.Documents used by tests to drive a
+// LogFollower without a real repository. Only Eventlog is implemented, so
+// tests must start the follower with CaughtUp set; embedding the interface
+// means any other method (CompactedEventlog included) panics with a nil
+// pointer dereference if a test accidentally exercises it.
+type fakeDocuments struct {
+	repository.Documents
+
+	mu sync.Mutex
+
+	// eventlog is served by Eventlog in order, one batch per call. Once
+	// exhausted, further calls return no items, as a real tail poll
+	// would once it has drained up to the current head.
+	eventlog [][]*repository.EventlogItem
+
+	eventlogCalls int
+}
+
+func (f *fakeDocuments) Eventlog(
+	_ context.Context, req *repository.GetEventlogRequest,
+) (*repository.GetEventlogResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if req.After == -1 {
+		return &repository.GetEventlogResponse{}, nil
+	}
+
+	idx := f.eventlogCalls
+	f.eventlogCalls++
+
+	if idx >= len(f.eventlog) {
+		return &repository.GetEventlogResponse{}, nil
+	}
+
+	return &repository.GetEventlogResponse{Items: f.eventlog[idx]}, nil
+}
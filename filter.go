@@ -0,0 +1,31 @@
+package koonkie
+
+import "github.com/ttab/elephant-api/repository"
+
+// matches reports whether item passes the follower's DocTypes, EventTypes
+// and Filter options. An empty DocTypes/EventTypes matches everything.
+func (lf *LogFollower) matches(item *repository.EventlogItem) bool {
+	if len(lf.docTypes) > 0 && !containsString(lf.docTypes, item.Type) {
+		return false
+	}
+
+	if len(lf.eventTypes) > 0 && !containsString(lf.eventTypes, item.Event) {
+		return false
+	}
+
+	if lf.filter != nil && !lf.filter(item) {
+		return false
+	}
+
+	return true
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, v := range haystack {
+		if v == needle {
+			return true
+		}
+	}
+
+	return false
+}
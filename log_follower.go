@@ -9,6 +9,8 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/ttab/elephant-api/repository"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type FollowerOptions struct {
@@ -17,6 +19,35 @@ type FollowerOptions struct {
 	StartAfter   int64
 	CaughtUp     bool
 	WaitDuration time.Duration
+
+	// Checkpoint, if set, is used by Run to load the starting position
+	// before the first poll and to persist the position after each
+	// successfully handled batch.
+	Checkpoint CheckpointStore
+	// RetryPolicy configures the backoff used by Run when a handler or
+	// an upstream RPC call fails. Defaults to DefaultRetryPolicy.
+	RetryPolicy RetryPolicy
+
+	// DocTypes restricts the follower to the given document types. DocType
+	// is kept for backwards compatibility and is treated as an additional
+	// entry in DocTypes.
+	DocTypes []string
+	// EventTypes restricts the follower to the given event types.
+	EventTypes []string
+	// CompactedWindowSize overrides the default chunk size used when
+	// scanning the compacted log, letting operators trade off request
+	// count against how much of the log is scanned per round trip when
+	// filters are selective. Defaults to compactedBlockSize.
+	CompactedWindowSize int64
+	// Filter, if set, is applied in addition to DocTypes/EventTypes and
+	// can express predicates the server-side filters can't, such as
+	// matching on event payload contents.
+	Filter func(*repository.EventlogItem) bool
+
+	// Tracer is used to create spans around poll cycles, RPC calls, and
+	// (when using Run) handler invocations. Defaults to a no-op tracer,
+	// so tracing stays opt-in.
+	Tracer trace.Tracer
 }
 
 // NewLogFollower creates a new follower
@@ -24,30 +55,77 @@ func NewLogFollower(
 	docs repository.Documents,
 	opts FollowerOptions,
 ) *LogFollower {
+	metrics := opts.Metrics
+	if metrics == nil {
+		metrics = noopFollowerMetrics{}
+	}
+
+	retryPolicy := opts.RetryPolicy
+	if retryPolicy.isZero() {
+		retryPolicy = DefaultRetryPolicy
+	}
+
+	docTypes := opts.DocTypes
+	if opts.DocType != "" {
+		docTypes = append(append([]string{}, docTypes...), opts.DocType)
+	}
+
+	compactedWindowSize := opts.CompactedWindowSize
+	if compactedWindowSize <= 0 {
+		compactedWindowSize = compactedBlockSize
+	}
+
+	position := opts.StartAfter
+
+	tracer := opts.Tracer
+	if tracer == nil {
+		tracer = defaultTracer
+	}
+
 	return &LogFollower{
 		docs:     docs,
-		docType:  opts.DocType,
-		position: opts.StartAfter,
+		position: position,
 		caughtUp: opts.CaughtUp,
 		wait: int32(min( //nolint: gosec
 			opts.WaitDuration.Milliseconds(),
 			math.MaxInt32,
 		)),
+		waitDuration:        opts.WaitDuration,
+		metrics:             metrics,
+		checkpoint:          opts.Checkpoint,
+		retryPolicy:         retryPolicy,
+		docTypes:            docTypes,
+		eventTypes:          opts.EventTypes,
+		filter:              opts.Filter,
+		compactedWindowSize: compactedWindowSize,
+		tracer:              tracer,
 	}
 }
 
 type LogFollower struct {
-	docs     repository.Documents
-	docType  string
-	position int64
-	caughtUp bool
-	wait     int32
-
-	metrics FollowerMetrics
+	docs         repository.Documents
+	position     int64
+	caughtUp     bool
+	wait         int32
+	waitDuration time.Duration
+
+	docTypes            []string
+	eventTypes          []string
+	filter              func(*repository.EventlogItem) bool
+	compactedWindowSize int64
+
+	metrics     FollowerMetrics
+	checkpoint  CheckpointStore
+	retryPolicy RetryPolicy
+	tracer      trace.Tracer
 
 	m              sync.Mutex
 	endCompactRead bool
 	endCompact     int64
+
+	lastBatchObserved int
+
+	lastKnownHead int64
 }
 
 const (
@@ -65,27 +143,30 @@ func (lf *LogFollower) GetState() (int64, bool) {
 func (lf *LogFollower) GetNext(
 	ctx context.Context,
 ) ([]*repository.EventlogItem, error) {
+	ctx, span := lf.tracer.Start(ctx, "koonkie.GetNext")
+	defer span.End()
+
 	var items []*repository.EventlogItem
 
 	err := lf.checkLogEnd(ctx)
 	if err != nil {
+		span.RecordError(err)
+
 		return nil, err
 	}
 
-	// TODO: The ergonomics of Eventlog/CompactedEventlog are a bit bad,
-	// both should be able to filter by doc type and event type, and they
-	// should return a "lastEvaluatedID" for pagination purposes. Compacted
-	// eventlog becomes inscrutable otherwise as it only will return empty
-	// results if nothing matches the filter in the compacted window.
-
 	if lf.caughtUp {
 		items, err = lf.pollEventlog(ctx)
 		if err != nil {
+			span.RecordError(err)
+
 			return nil, err
 		}
 	} else {
 		items, err = lf.pollCompactedEventlog(ctx)
 		if err != nil {
+			span.RecordError(err)
+
 			return nil, err
 		}
 	}
@@ -95,65 +176,141 @@ func (lf *LogFollower) GetNext(
 		state = "tail"
 	}
 
-	if lf.metrics != nil {
-		lf.metrics.SetPosition(state, lf.position)
+	span.SetAttributes(
+		attribute.String("state", state),
+		attribute.Int64("position", lf.position),
+		attribute.Int("batch_size", lf.lastBatchObserved),
+		attribute.Int("filtered_size", len(items)),
+	)
+
+	lf.metrics.SetPosition(state, lf.position)
+
+	lag := lf.endCompact - lf.position
+	if lf.caughtUp {
+		lag = lf.lastKnownHead - lf.position
 	}
 
+	lf.metrics.SetLag(state, lag)
+
 	return items, nil
 }
 
 func (lf *LogFollower) pollEventlog(
 	ctx context.Context,
 ) ([]*repository.EventlogItem, error) {
+	ctx, span := lf.tracer.Start(ctx, "koonkie.Eventlog")
+	defer span.End()
+
+	start := time.Now()
+
 	res, err := lf.docs.Eventlog(ctx,
 		&repository.GetEventlogRequest{
 			After:     lf.position,
 			BatchSize: eventlogBatchSize,
 			WaitMs:    lf.wait,
 		})
+
+	lf.metrics.ObserveRPCDuration("tail", outcomeFor(err), time.Since(start))
+
 	if err != nil {
+		lf.metrics.IncError("poll_eventlog")
+		span.RecordError(err)
+
 		return nil, fmt.Errorf("poll eventlog: %w", err)
 	}
 
 	var items []*repository.EventlogItem
 
 	for _, item := range res.Items {
-		if lf.docType != "" && item.Type != lf.docType {
+		if !lf.matches(item) {
 			continue
 		}
 
 		items = append(items, item)
 	}
 
+	lf.lastBatchObserved = len(res.Items)
+
 	if len(res.Items) > 0 {
 		lf.position = res.Items[len(res.Items)-1].Id
 	}
 
+	// A batch that wasn't full means that we've drained the log up to its
+	// current head, so the position we've reached is a trustworthy lower
+	// bound for the head. A full batch only tells us that the head is at
+	// least our position, so it raises the estimate only when we've
+	// passed it, which keeps the lag gauge from going negative without
+	// collapsing it to ~0 while we're still behind.
+	if len(res.Items) < eventlogBatchSize || lf.position > lf.lastKnownHead {
+		lf.lastKnownHead = lf.position
+	}
+
+	lf.metrics.ObserveBatchSize("tail", len(items))
+	lf.metrics.AddItemsObserved("tail", len(res.Items), len(items))
+
 	return items, nil
 }
 
 func (lf *LogFollower) pollCompactedEventlog(
 	ctx context.Context,
 ) ([]*repository.EventlogItem, error) {
-	until := min(lf.endCompact, lf.position+compactedBlockSize)
+	ctx, span := lf.tracer.Start(ctx, "koonkie.CompactedEventlog")
+	defer span.End()
+
+	until := min(lf.endCompact, lf.position+lf.compactedWindowSize)
+
+	// The request only supports filtering on a single type, so we only
+	// push it down when exactly one doc type is configured; the full
+	// filter (multiple doc types, event types, Filter) is still applied
+	// client-side below.
+	var serverType string
+	if len(lf.docTypes) == 1 {
+		serverType = lf.docTypes[0]
+	}
+
+	start := time.Now()
 
 	res, err := lf.docs.CompactedEventlog(ctx,
 		&repository.GetCompactedEventlogRequest{
 			After: lf.position,
 			Until: until,
-			Type:  lf.docType,
+			Type:  serverType,
 		})
+
+	lf.metrics.ObserveRPCDuration("compact", outcomeFor(err), time.Since(start))
+
 	if err != nil {
+		lf.metrics.IncError("poll_compacted")
+		span.RecordError(err)
+
 		return nil, fmt.Errorf("poll compacted eventlog: %w", err)
 	}
 
+	// Advance past the scanned window regardless of how many items
+	// matched, so that a narrow filter can't make the follower appear
+	// stuck.
 	lf.position = until
 
 	if until >= lf.endCompact {
 		lf.caughtUp = true
 	}
 
-	return res.Items, nil
+	var items []*repository.EventlogItem
+
+	for _, item := range res.Items {
+		if !lf.matches(item) {
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	lf.lastBatchObserved = len(res.Items)
+
+	lf.metrics.ObserveBatchSize("compact", len(items))
+	lf.metrics.AddItemsObserved("compact", len(res.Items), len(items))
+
+	return items, nil
 }
 
 func (lf *LogFollower) checkLogEnd(ctx context.Context) error {
@@ -164,17 +321,29 @@ func (lf *LogFollower) checkLogEnd(ctx context.Context) error {
 		return nil
 	}
 
+	ctx, span := lf.tracer.Start(ctx, "koonkie.ReadLastEvent")
+	defer span.End()
+
+	start := time.Now()
+
 	res, err := lf.docs.Eventlog(ctx,
 		&repository.GetEventlogRequest{
 			After: -1,
 		})
+
+	lf.metrics.ObserveRPCDuration("read_last", outcomeFor(err), time.Since(start))
+
 	if err != nil {
+		lf.metrics.IncError("read_last")
+		span.RecordError(err)
+
 		return fmt.Errorf(
 			"read last event in eventlog: %w", err)
 	}
 
 	if len(res.Items) > 0 {
 		lf.endCompact = res.Items[0].Id
+		lf.lastKnownHead = res.Items[0].Id
 	}
 
 	lf.endCompactRead = true
@@ -182,8 +351,40 @@ func (lf *LogFollower) checkLogEnd(ctx context.Context) error {
 	return nil
 }
 
+func outcomeFor(err error) string {
+	if err != nil {
+		return "error"
+	}
+
+	return "ok"
+}
+
+// FollowerMetrics is the instrumentation hook for LogFollower. A nil
+// FollowerMetrics is never passed to a follower internally; use
+// NewPrometheusFollowerMetrics, or implement your own, and leave
+// FollowerOptions.Metrics unset to get a no-op implementation.
 type FollowerMetrics interface {
+	// SetPosition reports the current log position for the given state
+	// ("compact" or "tail").
 	SetPosition(state string, position int64)
+	// SetLag reports how many events the follower is behind the head of
+	// the log it is reading from in the given state.
+	SetLag(state string, lag int64)
+	// ObserveRPCDuration reports the duration of an Eventlog/
+	// CompactedEventlog RPC, labelled by mode ("compact", "tail" or
+	// "read_last") and outcome ("ok" or "error").
+	ObserveRPCDuration(mode string, outcome string, duration time.Duration)
+	// ObserveBatchSize reports the number of items returned to the
+	// caller (after doc type, event type and Filter filtering) for a poll
+	// in the given mode.
+	ObserveBatchSize(mode string, size int)
+	// IncError counts an upstream error, labelled by kind ("poll_eventlog",
+	// "poll_compacted" or "read_last").
+	IncError(kind string)
+	// AddItemsObserved counts the items seen in a poll response versus
+	// how many survived doc type, event type and Filter filtering, in the
+	// given mode.
+	AddItemsObserved(mode string, observed, delivered int)
 }
 
 func NewPrometheusFollowerMetrics(
@@ -200,8 +401,70 @@ func NewPrometheusFollowerMetrics(
 		return nil, fmt.Errorf("failed to register log position metric: %w", err)
 	}
 
+	lag := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "eventlog_follower_lag",
+			Help: "Number of events the follower is behind the head of the log",
+		},
+		[]string{"follower", "state"},
+	)
+	if err := reg.Register(lag); err != nil {
+		return nil, fmt.Errorf("failed to register follower lag metric: %w", err)
+	}
+
+	rpcDuration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "eventlog_follower_rpc_duration_seconds",
+			Help:    "Duration of Eventlog/CompactedEventlog RPC calls",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"follower", "mode", "outcome"},
+	)
+	if err := reg.Register(rpcDuration); err != nil {
+		return nil, fmt.Errorf("failed to register RPC duration metric: %w", err)
+	}
+
+	batchSize := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "eventlog_follower_batch_size",
+			Help:    "Number of items returned for a poll, after filtering",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+		},
+		[]string{"follower", "mode"},
+	)
+	if err := reg.Register(batchSize); err != nil {
+		return nil, fmt.Errorf("failed to register batch size metric: %w", err)
+	}
+
+	errors := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "eventlog_follower_errors_total",
+			Help: "Number of errors encountered while polling the eventlog",
+		},
+		[]string{"follower", "kind"},
+	)
+	if err := reg.Register(errors); err != nil {
+		return nil, fmt.Errorf("failed to register errors metric: %w", err)
+	}
+
+	items := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "eventlog_follower_items_total",
+			Help: "Number of items observed versus delivered after filtering",
+		},
+		[]string{"follower", "mode", "stage"},
+	)
+	if err := reg.Register(items); err != nil {
+		return nil, fmt.Errorf("failed to register items metric: %w", err)
+	}
+
 	return &PrometheusFollowerMetrics{
 		logPosition: logPosition,
+		lag:         lag,
+		rpcDuration: rpcDuration,
+		batchSize:   batchSize,
+		errors:      errors,
+		items:       items,
 	}, nil
 }
 
@@ -210,6 +473,11 @@ var _ FollowerMetrics = &PrometheusFollowerMetrics{}
 type PrometheusFollowerMetrics struct {
 	name        string
 	logPosition *prometheus.GaugeVec
+	lag         *prometheus.GaugeVec
+	rpcDuration *prometheus.HistogramVec
+	batchSize   *prometheus.HistogramVec
+	errors      *prometheus.CounterVec
+	items       *prometheus.CounterVec
 }
 
 // SetPosition implements FollowerMetrics.
@@ -217,10 +485,56 @@ func (p *PrometheusFollowerMetrics) SetPosition(state string, position int64) {
 	p.logPosition.WithLabelValues(p.name, state).Set(float64(position))
 }
 
+// SetLag implements FollowerMetrics.
+func (p *PrometheusFollowerMetrics) SetLag(state string, lag int64) {
+	p.lag.WithLabelValues(p.name, state).Set(float64(lag))
+}
+
+// ObserveRPCDuration implements FollowerMetrics.
+func (p *PrometheusFollowerMetrics) ObserveRPCDuration(
+	mode string, outcome string, duration time.Duration,
+) {
+	p.rpcDuration.WithLabelValues(p.name, mode, outcome).Observe(duration.Seconds())
+}
+
+// ObserveBatchSize implements FollowerMetrics.
+func (p *PrometheusFollowerMetrics) ObserveBatchSize(mode string, size int) {
+	p.batchSize.WithLabelValues(p.name, mode).Observe(float64(size))
+}
+
+// IncError implements FollowerMetrics.
+func (p *PrometheusFollowerMetrics) IncError(kind string) {
+	p.errors.WithLabelValues(p.name, kind).Inc()
+}
+
+// AddItemsObserved implements FollowerMetrics.
+func (p *PrometheusFollowerMetrics) AddItemsObserved(mode string, observed, delivered int) {
+	p.items.WithLabelValues(p.name, mode, "observed").Add(float64(observed))
+	p.items.WithLabelValues(p.name, mode, "delivered").Add(float64(delivered))
+}
+
 // WithName creates a separate instance with another follower name.
 func (p *PrometheusFollowerMetrics) WithName(followerName string) *PrometheusFollowerMetrics {
 	return &PrometheusFollowerMetrics{
 		name:        followerName,
 		logPosition: p.logPosition,
+		lag:         p.lag,
+		rpcDuration: p.rpcDuration,
+		batchSize:   p.batchSize,
+		errors:      p.errors,
+		items:       p.items,
 	}
 }
+
+var _ FollowerMetrics = noopFollowerMetrics{}
+
+// noopFollowerMetrics is the default FollowerMetrics used when
+// FollowerOptions.Metrics is left unset.
+type noopFollowerMetrics struct{}
+
+func (noopFollowerMetrics) SetPosition(string, int64)                        {}
+func (noopFollowerMetrics) SetLag(string, int64)                             {}
+func (noopFollowerMetrics) ObserveRPCDuration(string, string, time.Duration) {}
+func (noopFollowerMetrics) ObserveBatchSize(string, int)                     {}
+func (noopFollowerMetrics) IncError(string)                                  {}
+func (noopFollowerMetrics) AddItemsObserved(string, int, int)                {}
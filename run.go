@@ -0,0 +1,182 @@
+package koonkie
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/ttab/elephant-api/repository"
+)
+
+// RetryPolicy configures the exponential backoff with jitter used by Run
+// when a handler or an upstream RPC call fails.
+type RetryPolicy struct {
+	// InitialInterval is the backoff duration after the first failure.
+	InitialInterval time.Duration
+	// MaxInterval caps the backoff duration before jitter is applied, so
+	// a jittered duration can exceed it by up to the Jitter fraction.
+	MaxInterval time.Duration
+	// Multiplier is applied to the backoff duration after every
+	// consecutive failure.
+	Multiplier float64
+	// Jitter is the fraction (0-1) of the backoff duration that is
+	// randomised to avoid thundering-herd retries.
+	Jitter float64
+}
+
+func (p RetryPolicy) isZero() bool {
+	return p == RetryPolicy{}
+}
+
+// DefaultRetryPolicy is used when FollowerOptions.RetryPolicy is left unset.
+var DefaultRetryPolicy = RetryPolicy{
+	InitialInterval: 500 * time.Millisecond,
+	MaxInterval:     30 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.2,
+}
+
+func (p RetryPolicy) next(attempt int) time.Duration {
+	d := float64(p.InitialInterval) * math.Pow(p.Multiplier, float64(attempt))
+
+	if maxInterval := float64(p.MaxInterval); d > maxInterval {
+		d = maxInterval
+	}
+
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (rand.Float64()*2 - 1) //nolint:gosec
+	}
+
+	if d < 0 {
+		d = 0
+	}
+
+	return time.Duration(d)
+}
+
+// CheckpointStore persists the position a LogFollower has reached so that
+// Run can resume from where it left off after a restart.
+type CheckpointStore interface {
+	// Load returns the position to start following from. Implementations
+	// should return 0 when no checkpoint has been saved yet.
+	Load(ctx context.Context) (int64, error)
+	// Save persists the given position.
+	Save(ctx context.Context, position int64) error
+}
+
+// Run drives the follower, calling handle with every batch of items it
+// receives. It loads the starting position from FollowerOptions.Checkpoint
+// (if set) before the first poll, and saves the position after every
+// successfully handled batch.
+//
+// Errors from upstream RPC calls and from handle are retried with
+// exponential backoff according to FollowerOptions.RetryPolicy. Run returns
+// when ctx is cancelled, at which point it returns ctx.Err().
+func (lf *LogFollower) Run(
+	ctx context.Context,
+	handle func(ctx context.Context, items []*repository.EventlogItem) error,
+) error {
+	if lf.checkpoint != nil {
+		position, err := lf.checkpoint.Load(ctx)
+		if err != nil {
+			return fmt.Errorf("load checkpoint: %w", err)
+		}
+
+		lf.position = position
+	}
+
+	var attempt int
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		items, err := lf.GetNext(ctx)
+		if err != nil {
+			if waitErr := lf.backoff(ctx, attempt); waitErr != nil {
+				return waitErr
+			}
+
+			attempt++
+
+			continue
+		}
+
+		if len(items) == 0 {
+			if waitErr := sleepContext(ctx, lf.waitDuration); waitErr != nil {
+				return waitErr
+			}
+
+			continue
+		}
+
+		// GetNext has already advanced past items, so a handler
+		// failure must retry the same items rather than falling
+		// through to a fresh poll, or they'd be lost for the rest of
+		// the process's lifetime.
+		for {
+			err = lf.handle(ctx, items, handle)
+			if err == nil {
+				break
+			}
+
+			if waitErr := lf.backoff(ctx, attempt); waitErr != nil {
+				return waitErr
+			}
+
+			attempt++
+		}
+
+		attempt = 0
+
+		if lf.checkpoint != nil {
+			err = lf.checkpoint.Save(ctx, lf.position)
+			if err != nil {
+				return fmt.Errorf("save checkpoint: %w", err)
+			}
+		}
+	}
+}
+
+func (lf *LogFollower) handle(
+	ctx context.Context,
+	items []*repository.EventlogItem,
+	handle func(ctx context.Context, items []*repository.EventlogItem) error,
+) error {
+	ctx, span := lf.tracer.Start(ctx, "koonkie.Handle")
+	defer span.End()
+
+	err := handle(ctx, items)
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	return err
+}
+
+func (lf *LogFollower) backoff(ctx context.Context, attempt int) error {
+	return sleepContext(ctx, lf.retryPolicy.next(attempt))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
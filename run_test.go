@@ -0,0 +1,166 @@
+package koonkie
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ttab/elephant-api/repository"
+)
+
+func TestRetryPolicyNextClampsToMaxInterval(t *testing.T) {
+	policy := RetryPolicy{
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     time.Second,
+		Multiplier:      2,
+		Jitter:          0.5,
+	}
+
+	for attempt := 0; attempt < 20; attempt++ {
+		d := policy.next(attempt)
+
+		upperBound := time.Duration(float64(policy.MaxInterval) * (1 + policy.Jitter))
+
+		if d < 0 {
+			t.Fatalf("attempt %d: next returned negative duration %s", attempt, d)
+		}
+
+		if d > upperBound {
+			t.Fatalf("attempt %d: next returned %s, want <= %s", attempt, d, upperBound)
+		}
+	}
+}
+
+func TestRetryPolicyNextNoNegativeJitter(t *testing.T) {
+	policy := RetryPolicy{
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Second,
+		Multiplier:      1,
+		Jitter:          1,
+	}
+
+	for i := 0; i < 100; i++ {
+		if d := policy.next(0); d < 0 {
+			t.Fatalf("next returned negative duration %s", d)
+		}
+	}
+}
+
+func TestRunLoadsAndSavesCheckpoint(t *testing.T) {
+	docs := &fakeDocuments{
+		eventlog: [][]*repository.EventlogItem{
+			{{Id: 11, Uuid: "doc-a"}, {Id: 12, Uuid: "doc-b"}},
+		},
+	}
+
+	checkpoint := NewMemoryCheckpointStore(10)
+
+	lf := NewLogFollower(docs, FollowerOptions{
+		Checkpoint: checkpoint,
+		CaughtUp:   true,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var handled []int64
+
+	err := lf.Run(ctx, func(_ context.Context, items []*repository.EventlogItem) error {
+		for _, item := range items {
+			handled = append(handled, item.Id)
+		}
+
+		cancel()
+
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run returned %v, want context.Canceled", err)
+	}
+
+	if len(handled) != 2 || handled[0] != 11 || handled[1] != 12 {
+		t.Fatalf("got handled items %v, want [11 12]", handled)
+	}
+
+	position, err := checkpoint.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if position != 12 {
+		t.Fatalf("checkpoint position = %d, want 12", position)
+	}
+}
+
+func TestRunRetriesHandlerErrorsWithSameBatch(t *testing.T) {
+	// Distinct IDs per batch, so a bug that falls through to a fresh
+	// GetNext call on handler failure (instead of retrying the batch it
+	// was given) shows up as a missing ID rather than being masked by
+	// every batch looking identical.
+	docs := &fakeDocuments{
+		eventlog: [][]*repository.EventlogItem{
+			{{Id: 1, Uuid: "doc-a"}},
+			{{Id: 2, Uuid: "doc-b"}},
+			{{Id: 3, Uuid: "doc-c"}},
+		},
+	}
+
+	checkpoint := NewMemoryCheckpointStore(0)
+
+	lf := NewLogFollower(docs, FollowerOptions{
+		Checkpoint: checkpoint,
+		CaughtUp:   true,
+		RetryPolicy: RetryPolicy{
+			InitialInterval: time.Millisecond,
+			MaxInterval:     time.Millisecond,
+			Multiplier:      1,
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var (
+		handled       []int64
+		firstAttempts int
+	)
+
+	err := lf.Run(ctx, func(_ context.Context, items []*repository.EventlogItem) error {
+		id := items[0].Id
+
+		if id == 1 {
+			firstAttempts++
+
+			if firstAttempts < 3 {
+				return errors.New("transient failure")
+			}
+		}
+
+		handled = append(handled, id)
+
+		if id == 3 {
+			cancel()
+		}
+
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run returned %v, want context.Canceled", err)
+	}
+
+	if firstAttempts != 3 {
+		t.Fatalf("batch {Id:1} handled %d times, want 3", firstAttempts)
+	}
+
+	if len(handled) != 3 || handled[0] != 1 || handled[1] != 2 || handled[2] != 3 {
+		t.Fatalf("got handled items %v, want [1 2 3]", handled)
+	}
+
+	position, err := checkpoint.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if position != 3 {
+		t.Fatalf("checkpoint position = %d, want 3", position)
+	}
+}
@@ -0,0 +1,7 @@
+package koonkie
+
+import "go.opentelemetry.io/otel/trace/noop"
+
+// defaultTracer is used when FollowerOptions.Tracer is left unset, keeping
+// tracing opt-in without requiring nil checks throughout LogFollower.
+var defaultTracer = noop.NewTracerProvider().Tracer("github.com/ttab/koonkie")